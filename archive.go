@@ -0,0 +1,208 @@
+package gitsync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// maxCachedArchiveBytes bounds how large an archive is kept in memory
+// between requests. Archives larger than this are still served, but are
+// rebuilt on every request rather than cached.
+const maxCachedArchiveBytes = 64 << 20 // 64 MiB
+
+// ArchiveHandler returns an http.Handler that streams the current worktree
+// of s as an archive in the given format, which must be "tar.gz" or "zip".
+// Responses are keyed by the HEAD commit hash via an ETag, so clients can
+// conditionally fetch via If-None-Match, and the most recently built
+// archive is cached in memory so that repeated requests for an unchanged
+// HEAD are cheap.
+func ArchiveHandler(s *Synchronizer, format string) http.Handler {
+	return &archiveHandler{sync: s, format: format}
+}
+
+type archiveHandler struct {
+	sync   *Synchronizer
+	format string
+
+	cacheMutex sync.Mutex
+	cacheHead  string
+	cacheData  []byte
+}
+
+func (h *archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, err := h.sync.currentPath()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to open repository: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to determine HEAD: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	etag := `"` + headRef.Hash().String() + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := h.archive(repo, headRef.Hash())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to build archive: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", contentType(h.format))
+	w.Write(data)
+}
+
+// archive returns the archived bytes for head, using the in-memory cache
+// when it is still current.
+func (h *archiveHandler) archive(repo *git.Repository, head plumbing.Hash) ([]byte, error) {
+	h.cacheMutex.Lock()
+	defer h.cacheMutex.Unlock()
+
+	if h.cacheHead == head.String() && h.cacheData != nil {
+		return h.cacheData, nil
+	}
+
+	commit, err := repo.CommitObject(head)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load commit %s: %v", head, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tree for commit %s: %v", head, err)
+	}
+
+	var buf bytes.Buffer
+	switch h.format {
+	case "zip":
+		err = writeZip(&buf, tree)
+	case "tar.gz":
+		err = writeTarGz(&buf, tree)
+	default:
+		err = fmt.Errorf("unsupported archive format %q", h.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if len(data) <= maxCachedArchiveBytes {
+		h.cacheHead = head.String()
+		h.cacheData = data
+	} else {
+		h.cacheHead = ""
+		h.cacheData = nil
+	}
+	return data, nil
+}
+
+func contentType(format string) string {
+	if format == "zip" {
+		return "application/zip"
+	}
+	return "application/gzip"
+}
+
+// writeTarGz streams every file in tree into a gzipped tar archive written
+// to w.
+func writeTarGz(w io.Writer, tree *object.Tree) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return fmt.Errorf("unable to translate mode for %s: %v", f.Name, err)
+		}
+
+		hdr := &tar.Header{
+			Name: f.Name,
+			Mode: int64(mode.Perm()),
+		}
+		if f.Mode == filemode.Symlink {
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = content
+			return tw.WriteHeader(hdr)
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write([]byte(content))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeZip streams every file in tree into a zip archive written to w.
+func writeZip(w io.Writer, tree *object.Tree) error {
+	zw := zip.NewWriter(w)
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return fmt.Errorf("unable to translate mode for %s: %v", f.Name, err)
+		}
+
+		header := &zip.FileHeader{
+			Name:   f.Name,
+			Method: zip.Deflate,
+		}
+		header.SetMode(mode)
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = entry.Write([]byte(content))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
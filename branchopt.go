@@ -4,9 +4,30 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
-// Branch returns an option that sets the given branch name.
+// Branch returns an option that sets the given branch name. It is the
+// primary branch: the one reflected in the worktree.
 func Branch(name string) Option {
 	return func(s *Synchronizer) {
 		s.branch = plumbing.ReferenceName("refs/heads/" + name)
 	}
 }
+
+// AllBranches returns an option that causes every remote branch to be kept
+// as an up to date local reference, in addition to the primary branch set
+// by Branch. Non-primary branches are updated as bare references; they are
+// not reflected in the worktree.
+func AllBranches() Option {
+	return func(s *Synchronizer) {
+		s.allBranches = true
+	}
+}
+
+// BranchFilter returns an option that causes every remote branch accepted
+// by the given function to be kept as an up to date local reference, in
+// addition to the primary branch set by Branch. Non-primary branches are
+// updated as bare references; they are not reflected in the worktree.
+func BranchFilter(accept func(name string) bool) Option {
+	return func(s *Synchronizer) {
+		s.branchFilter = accept
+	}
+}
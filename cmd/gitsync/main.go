@@ -16,8 +16,9 @@ func main() {
 		fmt.Fprintf(os.Stderr,
 			"%s\n\n"+
 				"usage: %s <command>\n"+
-				"       %s mirror -repo <path> -origin <url> [-branch <branch>]\n",
-			message, os.Args[0], os.Args[0])
+				"       %s mirror -repo <path> -origin <url> [-branch <branch>]\n"+
+				"       %s serve -repo <path> -origin <url> [-branch <branch>] [-addr <addr>] [-interval <duration>]\n",
+			message, os.Args[0], os.Args[0], os.Args[0])
 		os.Exit(2)
 	}
 
@@ -31,6 +32,8 @@ func main() {
 	switch command {
 	case "mirror":
 		mirror(command, args)
+	case "serve":
+		serve(command, args)
 	//case "update":
 	//	update(command, args)
 	default:
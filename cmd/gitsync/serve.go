@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gentlemanautomaton/gitsync"
+	"github.com/gentlemanautomaton/gitsync/daemon"
+)
+
+func serve(command string, args []string) {
+	var (
+		repo     string
+		origin   string
+		branch   string
+		addr     string
+		interval time.Duration
+	)
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&repo, "repo", "", "path of directory to sync")
+	fs.StringVar(&origin, "origin", "", "URL of origin repository")
+	fs.StringVar(&branch, "branch", "master", "branch to sync with")
+	fs.StringVar(&addr, "addr", ":8080", "address for the HTTP status and control endpoints")
+	fs.DurationVar(&interval, "interval", time.Minute, "interval between syncs")
+	fs.Parse(args)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s -repo <path> -origin <url> [-branch <branch>] [-addr <addr>] [-interval <duration>]\n", os.Args[0], command)
+		fs.PrintDefaults()
+	}
+
+	usage := func(message string) {
+		fmt.Printf("%s\n\n", message)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if repo == "" {
+		usage("No repository specified.")
+	}
+
+	if origin == "" {
+		usage("No origin specified.")
+	}
+
+	if branch == "" {
+		usage("No branch specified.")
+	}
+
+	s := gitsync.New(repo, origin, gitsync.Branch(branch), gitsync.Progress(os.Stdout))
+	w := gitsync.NewWorker(s)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: daemon.New(w).Handler(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			abort(err)
+		}
+	}()
+
+	if err := w.Run(ctx, interval); err != nil {
+		abort(err)
+	}
+}
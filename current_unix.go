@@ -0,0 +1,30 @@
+// +build !windows
+
+package gitsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// currentName is the name of the marker that identifies the active
+// snapshot within a Synchronizer's root directory.
+const currentName = "current"
+
+// getCurrent returns the snapshot directory name (or path) that "current"
+// points to within base.
+func getCurrent(base string) (string, error) {
+	return os.Readlink(filepath.Join(base, currentName))
+}
+
+// setCurrent atomically repoints "current" at target within base.
+func setCurrent(base, target string) error {
+	path := filepath.Join(base, currentName)
+	tmp := path + ".tmp"
+
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,36 @@
+// +build windows
+
+package gitsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// currentName is the name of the marker that identifies the active
+// snapshot within a Synchronizer's root directory.
+const currentName = "current"
+
+// getCurrent returns the snapshot directory name (or path) that "current"
+// points to within base. On Windows, creating a symlink normally requires
+// elevated privileges, so "current" is a plain text file containing the
+// target instead of a symlink.
+func getCurrent(base string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(base, currentName))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// setCurrent atomically repoints "current" at target within base.
+func setCurrent(base, target string) error {
+	path := filepath.Join(base, currentName)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(target), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gentlemanautomaton/gitsync"
+)
+
+// Server exposes HTTP endpoints for monitoring and controlling a
+// gitsync.Worker.
+type Server struct {
+	worker *gitsync.Worker
+}
+
+// New returns a Server that reports on and controls the given worker.
+func New(worker *gitsync.Worker) *Server {
+	return &Server{worker: worker}
+}
+
+// Handler returns an http.Handler that serves the daemon's endpoints:
+//
+//	GET  /healthz       always reports ok once the server is accepting requests
+//	GET  /status        reports the last sync time, last error and current HEAD
+//	POST /sync          requests an immediate resync, collapsing concurrent calls
+//	GET  /archive.tar.gz  streams the current worktree as a gzipped tarball
+//	GET  /archive.zip     streams the current worktree as a zip archive
+func (s *Server) Handler() http.Handler {
+	sync := s.worker.Synchronizer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/sync", s.handleSync)
+	mux.Handle("/archive.tar.gz", gitsync.ArchiveHandler(sync, "tar.gz"))
+	mux.Handle("/archive.zip", gitsync.ArchiveHandler(sync, "zip"))
+	return mux
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// status is the JSON body returned by the /status endpoint.
+type status struct {
+	LastSync  string `json:"lastSync,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	Head      string `json:"head,omitempty"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp := status{Head: s.worker.LastHead()}
+	if t := s.worker.LastSync(); !t.IsZero() {
+		resp.LastSync = t.Format(time.RFC3339)
+	}
+	if err := s.worker.LastError(); err != nil {
+		resp.LastError = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.worker.Trigger()
+	w.WriteHeader(http.StatusAccepted)
+}
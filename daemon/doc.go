@@ -0,0 +1,5 @@
+// Package daemon exposes a gitsync.Worker over HTTP so that it can run as
+// an always-on mirroring service. It provides health, status and
+// webhook-triggered sync endpoints suitable for use behind a load balancer
+// or a git host's webhook delivery.
+package daemon
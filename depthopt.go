@@ -0,0 +1,10 @@
+package gitsync
+
+// Depth returns an option that limits clones and pulls to the given number
+// of most recent commits. A depth of zero, the default, fetches full
+// history.
+func Depth(n int) Option {
+	return func(s *Synchronizer) {
+		s.depth = n
+	}
+}
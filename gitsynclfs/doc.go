@@ -0,0 +1,11 @@
+// Package gitsynclfs adds Git LFS support to gitsync. Importing this
+// package, even with a blank import, registers it as the provider behind
+// the gitsync.LFS option:
+//
+//	import _ "github.com/gentlemanautomaton/gitsync/gitsynclfs"
+//
+// go-git does not resolve LFS pointers on its own, so after a clone or pull
+// LFS-tracked files are left on disk as small text pointers rather than
+// their real content. gitsynclfs walks the worktree for pointer files and
+// downloads the objects they reference from the origin's LFS batch API.
+package gitsynclfs
@@ -0,0 +1,274 @@
+package gitsynclfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+
+	"github.com/gentlemanautomaton/gitsync"
+)
+
+func init() {
+	gitsync.RegisterLFS(Fetch)
+}
+
+// pointerPrefix is the first line of every Git LFS pointer file.
+const pointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// maxPointerSize bounds how much of a file is read before concluding that
+// it isn't an LFS pointer. Real pointer files are well under this size.
+const maxPointerSize = 1024
+
+// Fetch walks the worktree at path, identifies Git LFS pointer files, and
+// replaces them with the objects they reference, downloaded from origin's
+// LFS batch API. Non-pointer files are left untouched. auth is reused for
+// LFS requests when it is an HTTP basic auth method.
+func Fetch(ctx context.Context, path, origin string, auth transport.AuthMethod, progress sideband.Progress) error {
+	pointers, err := findPointers(path)
+	if err != nil {
+		return fmt.Errorf("unable to scan worktree for LFS pointers: %v", err)
+	}
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	client := client{origin: origin, auth: auth}
+
+	objects := make([]batchObject, 0, len(pointers))
+	for _, p := range pointers {
+		objects = append(objects, batchObject{OID: p.OID, Size: p.Size})
+	}
+
+	downloads, err := client.batch(ctx, objects)
+	if err != nil {
+		return fmt.Errorf("unable to negotiate LFS batch download: %v", err)
+	}
+
+	for file, p := range pointers {
+		href, ok := downloads[p.OID]
+		if !ok {
+			return fmt.Errorf("no download action returned for LFS object %s", p.OID)
+		}
+		if progress != nil {
+			fmt.Fprintf(progress, "Downloading LFS object %s\n", p.OID)
+		}
+		if err := client.download(ctx, href, file, p); err != nil {
+			return fmt.Errorf("unable to download LFS object %s: %v", p.OID, err)
+		}
+	}
+
+	return nil
+}
+
+// pointer is a parsed Git LFS pointer file.
+type pointer struct {
+	OID  string
+	Size int64
+}
+
+// findPointers walks path and returns the LFS pointers found there, keyed
+// by file path.
+func findPointers(path string) (map[string]pointer, error) {
+	pointers := make(map[string]pointer)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ptr, ok, err := readPointer(p, info.Size())
+		if err != nil {
+			return fmt.Errorf("unable to read \"%s\": %v", p, err)
+		}
+		if ok {
+			pointers[p] = ptr
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// readPointer reports whether file is a Git LFS pointer, and if so, parses
+// its oid and size fields.
+func readPointer(file string, size int64) (pointer, bool, error) {
+	if size > maxPointerSize {
+		return pointer{}, false, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return pointer{}, false, err
+	}
+	if !bytes.HasPrefix(data, []byte(pointerPrefix)) {
+		return pointer{}, false, nil
+	}
+
+	var p pointer
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "oid sha256:"):
+			p.OID = strings.TrimSpace(strings.TrimPrefix(line, "oid sha256:"))
+		case strings.HasPrefix(line, "size "):
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "size ")), 10, 64)
+			if err != nil {
+				return pointer{}, false, fmt.Errorf("invalid size field: %v", err)
+			}
+			p.Size = n
+		}
+	}
+
+	if p.OID == "" {
+		return pointer{}, false, fmt.Errorf("missing oid field")
+	}
+
+	return p, true, nil
+}
+
+// batchObject identifies an LFS object within a batch API request or
+// response.
+type batchObject struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// client issues requests against origin's LFS batch API.
+type client struct {
+	origin string
+	auth   transport.AuthMethod
+}
+
+func (c client) batch(ctx context.Context, objects []batchObject) (map[string]string, error) {
+	body, err := json.Marshal(struct {
+		Operation string        `json:"operation"`
+		Objects   []batchObject `json:"objects"`
+	}{
+		Operation: "download",
+		Objects:   objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.origin, "/")+"/info/lfs/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+	req.Header.Set("Accept", "application/vnd.git-lfs+json")
+	c.authenticate(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from LFS batch endpoint: %s", resp.Status)
+	}
+
+	var parsed struct {
+		Objects []struct {
+			OID     string `json:"oid"`
+			Actions struct {
+				Download struct {
+					Href string `json:"href"`
+				} `json:"download"`
+			} `json:"actions"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("unable to decode LFS batch response: %v", err)
+	}
+
+	downloads := make(map[string]string, len(parsed.Objects))
+	for _, obj := range parsed.Objects {
+		if obj.Error != nil {
+			return nil, fmt.Errorf("object %s: %s", obj.OID, obj.Error.Message)
+		}
+		downloads[obj.OID] = obj.Actions.Download.Href
+	}
+	return downloads, nil
+}
+
+func (c client) download(ctx context.Context, href, file string, p pointer) error {
+	req, err := http.NewRequest(http.MethodGet, href, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	c.authenticate(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status downloading object: %s", resp.Status)
+	}
+
+	tmp := file + ".lfstmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+	out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != p.OID {
+		os.Remove(tmp)
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", p.OID, sum)
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// authenticate attaches c.auth to req when it is an HTTP basic auth method
+// and req targets the same host as origin. Download actions returned by the
+// batch API commonly point at a different host (e.g. a pre-signed S3 or CDN
+// URL), and origin's credentials must not be leaked to it.
+func (c client) authenticate(req *http.Request) {
+	basic, ok := c.auth.(*githttp.BasicAuth)
+	if !ok {
+		return
+	}
+
+	originURL, err := url.Parse(c.origin)
+	if err != nil || req.URL.Host != originURL.Host {
+		return
+	}
+
+	req.SetBasicAuth(basic.Username, basic.Password)
+}
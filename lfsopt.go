@@ -0,0 +1,34 @@
+package gitsync
+
+import (
+	"context"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// LFS returns an option that, when enabled, downloads Git LFS content after
+// each successful clone or pull. Enabling this option requires importing a
+// package that registers an LFS provider via RegisterLFS, such as
+// gitsynclfs.
+func LFS(enabled bool) Option {
+	return func(s *Synchronizer) {
+		s.lfs = enabled
+	}
+}
+
+// LFSProvider downloads Git LFS content referenced by pointer files within
+// the worktree at path, using origin and auth to reach the LFS server.
+type LFSProvider func(ctx context.Context, path, origin string, auth transport.AuthMethod, progress sideband.Progress) error
+
+// lfsProvider is installed by RegisterLFS. It is left nil unless a package
+// such as gitsynclfs has been imported, so that programs that don't use LFS
+// avoid the dependency weight.
+var lfsProvider LFSProvider
+
+// RegisterLFS installs the given provider as the implementation used by the
+// LFS option. It is intended to be called from the init function of a
+// provider package, such as gitsynclfs, rather than called directly.
+func RegisterLFS(provider LFSProvider) {
+	lfsProvider = provider
+}
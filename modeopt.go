@@ -0,0 +1,33 @@
+package gitsync
+
+// InitMode controls how a Synchronizer initializes a local copy that does
+// not already exist on disk.
+type InitMode int
+
+const (
+	// ModeCheckout clones the repository and checks out the primary branch.
+	// This is the default.
+	ModeCheckout InitMode = iota
+
+	// ModeNoCheckout clones the repository without checking out a worktree,
+	// leaving only the .git directory populated.
+	ModeNoCheckout
+
+	// ModeInit creates an empty, non-bare repository and configures its
+	// origin remote without fetching anything. The local copy remains
+	// headless until a subsequent sync is performed in a mode that fetches.
+	ModeInit
+
+	// ModeInitPull initializes the repository synchronously, as ModeInit
+	// does, and then fetches and checks out the primary branch in a
+	// background goroutine. Its outcome is reported through
+	// Synchronizer.AsyncError once the goroutine completes.
+	ModeInitPull
+)
+
+// Mode returns an option that sets the given initialization mode.
+func Mode(mode InitMode) Option {
+	return func(s *Synchronizer) {
+		s.initMode = mode
+	}
+}
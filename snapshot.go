@@ -0,0 +1,167 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cloneOrPullSnapshot implements CloneOrPull when Snapshot has configured a
+// positive keep count. Rather than syncing in place, it seeds a fresh,
+// uniquely named directory from the previous snapshot, hardlinking its git
+// objects and copying everything else (so that unchanged objects and files
+// are not re-transferred), points s.path at it for the duration of the
+// sync, and on success repoints "current" at the new snapshot before
+// pruning old ones.
+func (s *Synchronizer) cloneOrPullSnapshot(ctx context.Context) error {
+	stamp := time.Now().Unix()
+	next := filepath.Join(s.root, strconv.FormatInt(stamp, 10))
+	for {
+		if _, err := os.Lstat(next); err != nil {
+			break
+		}
+		stamp++
+		next = filepath.Join(s.root, strconv.FormatInt(stamp, 10))
+	}
+
+	if prev, err := getCurrent(s.root); err == nil && prev != "" {
+		if !filepath.IsAbs(prev) {
+			prev = filepath.Join(s.root, prev)
+		}
+		s.printf("Seeding snapshot from \"%s\"\n", prev)
+		if err := hardlinkCopy(prev, next); err != nil {
+			return fmt.Errorf("unable to seed snapshot from previous copy: %v", err)
+		}
+	}
+
+	return s.syncSnapshot(ctx, next)
+}
+
+// syncSnapshot runs a normal sync against the given snapshot directory,
+// restoring s.path to s.root afterward regardless of outcome.
+func (s *Synchronizer) syncSnapshot(ctx context.Context, path string) error {
+	s.path = path
+	err := s.sync(ctx)
+	s.path = s.root
+	if err != nil {
+		return err
+	}
+
+	if err := setCurrent(s.root, filepath.Base(path)); err != nil {
+		return fmt.Errorf("unable to update current snapshot marker: %v", err)
+	}
+
+	return s.pruneSnapshots()
+}
+
+// pruneSnapshots removes the oldest timestamped snapshot directories under
+// s.root until at most s.snapshotKeep remain.
+func (s *Synchronizer) pruneSnapshots() error {
+	entries, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return fmt.Errorf("unable to list snapshots: %v", err)
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseInt(entry.Name(), 10, 64); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, entry.Name())
+	}
+
+	// Unix-second directory names sort lexicographically in chronological
+	// order for the foreseeable future, so a plain string sort suffices.
+	sort.Strings(snapshots)
+
+	if len(snapshots) <= s.snapshotKeep {
+		return nil
+	}
+
+	for _, name := range snapshots[:len(snapshots)-s.snapshotKeep] {
+		victim := filepath.Join(s.root, name)
+		s.printf("Pruning snapshot \"%s\"\n", victim)
+		if err := os.RemoveAll(victim); err != nil {
+			return fmt.Errorf("unable to prune snapshot \"%s\": %v", victim, err)
+		}
+	}
+
+	return nil
+}
+
+// gitObjectsDir is the path, relative to a repository's root, under which
+// git stores content-addressed objects. Files in this directory are never
+// modified in place once written, so they are safe to share between
+// snapshots via a hard link. Everything else (refs, the index, worktree
+// files, config) can be rewritten in place by a later sync, so it must be
+// copied instead of linked or a write would corrupt the older snapshot too.
+var gitObjectsDir = filepath.Join(".git", "objects")
+
+// hardlinkCopy recreates the directory tree rooted at src under dst.
+// Content-addressed objects under .git/objects are hardlinked so that
+// unchanged content is shared on disk rather than duplicated; everything
+// else is copied, since it may be rewritten in place by a later sync. It is
+// a no-op, not an error, if src does not exist.
+func hardlinkCopy(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case rel == gitObjectsDir || strings.HasPrefix(rel, gitObjectsDir+string(filepath.Separator)):
+			return os.Link(p, target)
+		default:
+			return copyFile(p, target, info.Mode())
+		}
+	})
+}
+
+// copyFile copies the contents of src to dst, creating dst with the given
+// mode rather than sharing src's inode.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
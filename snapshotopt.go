@@ -0,0 +1,15 @@
+package gitsync
+
+// Snapshot returns an option that causes each sync to be mirrored into a
+// new timestamped directory under path rather than updated in place. Once a
+// snapshot completes successfully, the "current" marker is repointed at it
+// atomically, and only the keep most recent snapshots are retained. This
+// gives callers cheap rollback: repointing "current" at an older snapshot
+// undoes a bad sync.
+//
+// A keep of zero or less disables snapshotting, which is the default.
+func Snapshot(keep int) Option {
+	return func(s *Synchronizer) {
+		s.snapshotKeep = keep
+	}
+}
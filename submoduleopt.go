@@ -0,0 +1,15 @@
+package gitsync
+
+import git "gopkg.in/src-d/go-git.v4"
+
+// Submodules returns an option that sets how deeply submodules are
+// recursed into during clones and kept up to date during pulls. The
+// default, git.NoRecurseSubmodules, leaves submodules uninitialized.
+//
+// Submodules are always cloned with their full history; go-git v4 does not
+// expose a way to clone them shallowly.
+func Submodules(mode git.SubmoduleRescursivity) Option {
+	return func(s *Synchronizer) {
+		s.submodules = mode
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	git "gopkg.in/src-d/go-git.v4"
@@ -14,14 +15,30 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
 
+// Option configures a Synchronizer. Options are applied in the order given
+// to New.
+type Option func(*Synchronizer)
+
 // Synchronizer is responsible for keeping local files in sync with a remote.
 // It should be created by calling gitsync.New.
 type Synchronizer struct {
-	path     string
-	origin   string
-	branch   plumbing.ReferenceName
-	progress sideband.Progress
-	auth     transport.AuthMethod
+	root         string
+	path         string
+	origin       string
+	branch       plumbing.ReferenceName
+	allBranches  bool
+	branchFilter func(name string) bool
+	depth        int
+	initMode     InitMode
+	lfs          bool
+	submodules   git.SubmoduleRescursivity
+	snapshotKeep int
+	progress     sideband.Progress
+	auth         transport.AuthMethod
+
+	asyncMutex       sync.Mutex
+	asyncErr         error
+	asyncInitStarted bool
 }
 
 // New returns a Synchronizer for the repository at the given path.
@@ -36,6 +53,7 @@ type Synchronizer struct {
 func New(path, origin string, options ...Option) *Synchronizer {
 	path, _ = filepath.Abs(path)
 	s := &Synchronizer{
+		root:   path,
 		path:   path,
 		origin: origin,
 	}
@@ -52,15 +70,43 @@ func New(path, origin string, options ...Option) *Synchronizer {
 // Sync is destructive. Files within the local copy may be discarded in order
 // for sync to accomplish its goal. In the case of failure sync may attempt to
 // destroy the local copy and re-clone.
+//
+// If Snapshot has been used to configure the Synchronizer, CloneOrPull
+// instead mirrors into a new timestamped directory and repoints the
+// "current" marker at it; see cloneOrPullSnapshot.
 func (s *Synchronizer) CloneOrPull(ctx context.Context) error {
+	if s.snapshotKeep > 0 {
+		return s.cloneOrPullSnapshot(ctx)
+	}
+	return s.sync(ctx)
+}
+
+// sync performs a single clone-or-pull against s.path.
+func (s *Synchronizer) sync(ctx context.Context) error {
 	start := time.Now()
 
-	repo, head, cloned, err := s.prepare(ctx)
+	repo, head, cloned, bare, err := s.prepare(ctx)
 	if err != nil {
 		return err
 	}
 
+	if bare {
+		if s.initMode == ModeInitPull {
+			s.startAsyncInit(ctx, repo)
+		}
+		s.printf("Sync completed in %s\n", time.Now().Sub(start))
+		return nil
+	}
+
 	if cloned {
+		err = s.syncBranches(ctx, repo)
+		if err != nil {
+			return err
+		}
+		err = s.syncLFS(ctx)
+		if err != nil {
+			return err
+		}
 		s.printf("Sync completed in %s\n", time.Now().Sub(start))
 		return nil
 	}
@@ -70,6 +116,11 @@ func (s *Synchronizer) CloneOrPull(ctx context.Context) error {
 		return err
 	}
 
+	err = s.syncBranches(ctx, repo)
+	if err != nil {
+		return err
+	}
+
 	s.printf("Opening worktree\n")
 	worktree, err := repo.Worktree()
 	if err != nil {
@@ -92,6 +143,7 @@ func (s *Synchronizer) CloneOrPull(ctx context.Context) error {
 		Progress:      s.progress,
 		Auth:          s.auth,
 		Force:         true,
+		Depth:         s.depth,
 	})
 	switch err {
 	case nil:
@@ -100,16 +152,73 @@ func (s *Synchronizer) CloneOrPull(ctx context.Context) error {
 		return fmt.Errorf("unable to pull: %v", err)
 	}
 
+	err = s.syncSubmodules(worktree)
+	if err != nil {
+		return err
+	}
+
+	err = s.syncLFS(ctx)
+	if err != nil {
+		return err
+	}
+
 	s.printf("Sync completed in %s\n", time.Now().Sub(start))
 
 	return nil
 }
 
-func (s *Synchronizer) prepare(ctx context.Context) (repo *git.Repository, head *plumbing.Reference, cloned bool, err error) {
+// Head returns the hash of the current HEAD reference of the local copy.
+// It fails if the local copy has not yet been cloned. When Snapshot is
+// enabled, it resolves through the "current" marker rather than s.path.
+func (s *Synchronizer) Head() (plumbing.Hash, error) {
+	path, err := s.currentPath()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to open repository located at \"%s\": %v", path, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to determine repository HEAD reference: %v", err)
+	}
+	return head.Hash(), nil
+}
+
+// currentPath returns the directory that should currently be treated as the
+// local copy: the snapshot pointed to by the "current" marker when Snapshot
+// is enabled, or s.path otherwise.
+func (s *Synchronizer) currentPath() (string, error) {
+	if s.snapshotKeep <= 0 {
+		return s.path, nil
+	}
+
+	current, err := getCurrent(s.root)
+	if err != nil {
+		return "", fmt.Errorf("unable to determine current snapshot: %v", err)
+	}
+	if !filepath.IsAbs(current) {
+		current = filepath.Join(s.root, current)
+	}
+	return current, nil
+}
+
+// AsyncError returns the error, if any, from the most recently completed
+// background initialization started under ModeInitPull. It returns nil if
+// no background initialization has completed yet or the most recent one
+// succeeded. While a retry is running, the previous failure is returned.
+func (s *Synchronizer) AsyncError() error {
+	s.asyncMutex.Lock()
+	defer s.asyncMutex.Unlock()
+	return s.asyncErr
+}
+
+func (s *Synchronizer) prepare(ctx context.Context) (repo *git.Repository, head *plumbing.Reference, cloned, bare bool, err error) {
 	const attempts = 2
 	for i := 0; i < attempts; i++ {
-		repo, cloned, err = s.openOrClone(ctx)
-		if err != nil {
+		repo, cloned, bare, err = s.openOrClone(ctx)
+		if err != nil || bare {
 			return
 		}
 
@@ -123,7 +232,7 @@ func (s *Synchronizer) prepare(ctx context.Context) (repo *git.Repository, head
 		if i < attempts {
 			// The initial clone failed somehow, possibly on a previous attempt
 			s.printf("The repository appears to be malformed\nAttempting delete and re-clone\n")
-			err = s.delete()
+			err = s.delete(repo)
 			if err != nil {
 				err = fmt.Errorf("unable to delete existing malformed repository: %v", err)
 				return
@@ -134,15 +243,25 @@ func (s *Synchronizer) prepare(ctx context.Context) (repo *git.Repository, head
 	return
 }
 
-func (s *Synchronizer) openOrClone(ctx context.Context) (repo *git.Repository, cloned bool, err error) {
+func (s *Synchronizer) openOrClone(ctx context.Context) (repo *git.Repository, cloned, bare bool, err error) {
 	s.printf("Opening repository at \"%s\"\n", s.path)
 	repo, err = s.open()
 	switch err {
 	case nil:
+		if s.initMode == ModeInit || s.initMode == ModeInitPull {
+			bare = s.headless(repo)
+		}
 	case git.ErrRepositoryNotExists:
-		s.printf("Repository does not exist\nCloning from %s\n", s.origin)
 		cloned = true
-		repo, err = s.clone(ctx)
+		switch s.initMode {
+		case ModeInit, ModeInitPull:
+			s.printf("Repository does not exist\nInitializing bare copy for %s\n", s.origin)
+			repo, err = s.init()
+			bare = true
+		default:
+			s.printf("Repository does not exist\nCloning from %s\n", s.origin)
+			repo, err = s.clone(ctx)
+		}
 	default:
 		err = fmt.Errorf("unable to open repository located at \"%s\": %v", s.path, err)
 	}
@@ -153,18 +272,120 @@ func (s *Synchronizer) open() (repo *git.Repository, err error) {
 	return git.PlainOpen(s.path)
 }
 
+// headless reports whether repo has no HEAD commit yet, which is the
+// expected state of a repository initialized by ModeInit or ModeInitPull
+// until a pull populates it. It is used to distinguish that expected state
+// from genuine repository corruption.
+func (s *Synchronizer) headless(repo *git.Repository) bool {
+	_, err := repo.Head()
+	return err == plumbing.ErrReferenceNotFound
+}
+
 func (s *Synchronizer) clone(ctx context.Context) (repo *git.Repository, err error) {
 	return git.PlainCloneContext(ctx, s.path, false, &git.CloneOptions{
-		URL:           s.origin,
-		ReferenceName: s.branch,
-		Progress:      s.progress,
-		Auth:          s.auth,
+		URL:               s.origin,
+		ReferenceName:     s.branch,
+		Progress:          s.progress,
+		Auth:              s.auth,
+		Depth:             s.depth,
+		NoCheckout:        s.initMode == ModeNoCheckout,
+		RecurseSubmodules: s.submodules,
+	})
+}
+
+// init creates an empty, non-bare repository at s.path and configures its
+// origin remote without fetching anything. It is used by ModeInit and
+// ModeInitPull.
+func (s *Synchronizer) init() (repo *git.Repository, err error) {
+	repo, err = git.PlainInit(s.path, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.updateOrigin(repo); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// startAsyncInit fetches and checks out the primary branch in the
+// background, recording its outcome so that it can be retrieved later via
+// AsyncError. It is used by ModeInitPull. It is a no-op if a background
+// fetch has already been started, so that repeated calls to CloneOrPull
+// while the repository is still headless do not pile up goroutines.
+func (s *Synchronizer) startAsyncInit(ctx context.Context, repo *git.Repository) {
+	s.asyncMutex.Lock()
+	if s.asyncInitStarted {
+		s.asyncMutex.Unlock()
+		return
+	}
+	s.asyncInitStarted = true
+	s.asyncMutex.Unlock()
+
+	s.printf("Fetching %s in the background\n", s.origin)
+	go func() {
+		s.finishAsyncInit(s.asyncInit(ctx, repo))
+	}()
+}
+
+// finishAsyncInit records the outcome of a background initialization started
+// by startAsyncInit. On failure it clears asyncInitStarted so that the next
+// headless sync retries the fetch instead of being stuck no-oping forever.
+func (s *Synchronizer) finishAsyncInit(err error) {
+	s.asyncMutex.Lock()
+	s.asyncErr = err
+	if err != nil {
+		s.asyncInitStarted = false
+	}
+	s.asyncMutex.Unlock()
+}
+
+func (s *Synchronizer) asyncInit(ctx context.Context, repo *git.Repository) error {
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", s.branch, s.branch))},
+		Auth:       s.auth,
+		Progress:   s.progress,
+		Depth:      s.depth,
+	})
+	switch err {
+	case nil, git.NoErrAlreadyUpToDate:
+	default:
+		return fmt.Errorf("unable to fetch %s: %v", s.origin, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("unable to open worktree: %v", err)
+	}
+
+	err = worktree.Checkout(&git.CheckoutOptions{
+		Branch: s.branch,
+		Force:  true,
 	})
+	if err != nil {
+		return fmt.Errorf("unable to check out %s branch: %v", s.branch.Short(), err)
+	}
+
+	return nil
 }
 
 // delete attempts to remove the git directory within s.path after performing
-// some sanity checks.
-func (s *Synchronizer) delete() error {
+// some sanity checks. If repo is non-nil, its submodule working copies are
+// removed first, since they live outside of the git directory and would
+// otherwise be left behind as stale clones after a re-clone.
+func (s *Synchronizer) delete(repo *git.Repository) error {
+	if repo != nil {
+		if worktree, err := repo.Worktree(); err == nil {
+			if subs, err := worktree.Submodules(); err == nil {
+				for _, sub := range subs {
+					subPath := filepath.Join(s.path, sub.Config().Path)
+					s.printf("Removing submodule working copy at \"%s\"\n", subPath)
+					os.RemoveAll(subPath)
+				}
+			}
+		}
+	}
+
 	// Make sure it looks like a repository
 	root, err := os.Stat(s.path)
 	if err != nil {
@@ -204,6 +425,106 @@ func (s *Synchronizer) fetch(ctx context.Context, repo *git.Repository) error {
 }
 */
 
+// syncBranches fetches every remote branch and writes a local reference for
+// each one accepted by s.branchFilter, without touching the worktree. It is
+// a no-op unless AllBranches or BranchFilter has been used to configure the
+// Synchronizer. The primary branch, s.branch, continues to be the one
+// reflected in the worktree by updateBranch.
+func (s *Synchronizer) syncBranches(ctx context.Context, repo *git.Repository) error {
+	if !s.allBranches && s.branchFilter == nil {
+		return nil
+	}
+
+	s.printf("Fetching remote branches\n")
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Auth:       s.auth,
+		Progress:   s.progress,
+	})
+	switch err {
+	case nil, git.NoErrAlreadyUpToDate:
+	default:
+		return fmt.Errorf("unable to fetch remote branches: %v", err)
+	}
+
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return fmt.Errorf("unable to access origin remote: %v", err)
+	}
+
+	refs, err := remote.List(&git.ListOptions{Auth: s.auth})
+	if err != nil {
+		return fmt.Errorf("unable to list remote references: %v", err)
+	}
+
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() || ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		short := ref.Name().Short()
+		if short == s.branch.Short() {
+			continue
+		}
+		if s.branchFilter != nil && !s.branchFilter(short) {
+			continue
+		}
+
+		local := plumbing.NewHashReference(plumbing.NewBranchReferenceName(short), ref.Hash())
+		if existing, err := repo.Storer.Reference(local.Name()); err == nil && existing.Hash() == local.Hash() {
+			continue
+		}
+
+		if err := repo.Storer.SetReference(local); err != nil {
+			return fmt.Errorf("unable to update %s branch reference: %v", short, err)
+		}
+	}
+
+	return nil
+}
+
+// syncSubmodules brings submodules up to date with the commit referenced by
+// the superproject. It is a no-op unless Submodules has configured a
+// recursion depth greater than git.NoRecurseSubmodules.
+func (s *Synchronizer) syncSubmodules(worktree *git.Worktree) error {
+	if s.submodules == git.NoRecurseSubmodules {
+		return nil
+	}
+
+	subs, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("unable to list submodules: %v", err)
+	}
+
+	for _, sub := range subs {
+		s.printf("Updating submodule %s\n", sub.Config().Name)
+		err = sub.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: s.submodules,
+			Auth:              s.auth,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to update submodule %s: %v", sub.Config().Name, err)
+		}
+	}
+
+	return nil
+}
+
+// syncLFS downloads Git LFS content if the LFS option is enabled.
+func (s *Synchronizer) syncLFS(ctx context.Context) error {
+	if !s.lfs {
+		return nil
+	}
+	if lfsProvider == nil {
+		return fmt.Errorf("LFS option enabled but no LFS provider is registered; import a package such as gitsynclfs")
+	}
+
+	s.printf("Fetching LFS content\n")
+	return lfsProvider(ctx, s.path, s.origin, s.auth, s.progress)
+}
+
 func (s *Synchronizer) updateOrigin(repo *git.Repository) error {
 	cfg := config.RemoteConfig{
 		Name: "origin",